@@ -0,0 +1,103 @@
+package store
+
+import (
+	"context"
+	"encoding/base64"
+	"strings"
+
+	e "github.com/pkg/errors"
+)
+
+// DefaultEquivalentLineDepth bounds how many historical revisions
+// ResolveEquivalentLine inspects before giving up.
+const DefaultEquivalentLineDepth = 50
+
+// FileRevisionProvider is implemented by SourceProviders that can list
+// historical revisions of a single file, for use by ResolveEquivalentLine.
+// Providers that don't implement it are simply skipped: ResolveEquivalentLine
+// returns ErrFileRevisionsUnsupported rather than treating the trace as
+// stale, since content genuinely can't be checked, not unequivalent.
+type FileRevisionProvider interface {
+	// ListFileRevisions returns up to maxDepth commit SHAs that touched
+	// filePath at or before ref, most recent first.
+	ListFileRevisions(ctx context.Context, repoPath string, filePath string, ref string, maxDepth int) ([]string, error)
+}
+
+// ErrFileRevisionsUnsupported is returned by ResolveEquivalentLine when
+// provider doesn't implement FileRevisionProvider. Callers should keep the
+// content they already resolved rather than treating this the same as a
+// history walk that found no equivalent revision.
+var ErrFileRevisionsUnsupported = e.New("source provider does not support listing file revisions")
+
+// windowAt renders the before/content/after lines ExpandedStackTrace returns
+// into a single comparable string.
+func windowAt(before, content, after *string) string {
+	return *before + "\n" + *content + "\n" + *after
+}
+
+// ResolveEquivalentLine is used when the exact commit a trace was built from
+// couldn't be resolved, so GitHubGitSHA fell back to a repo's latest commit.
+// Line numbers read at that fallback SHA may no longer match the crashed
+// build, so this walks the file's commit history (oldest-reachable-first
+// isn't required; ancestors are inspected in the order the provider returns
+// them) looking for the most recent revision whose window around lineNumber
+// is still byte-identical to referenceWindow - the window read at the
+// fallback SHA. Finding such a revision whose content is unchanged relative
+// to the fallback gives confidence the line hasn't drifted, even though the
+// exact deployed commit is unknown.
+//
+// It returns a resolved equivalent SHA on a match; (nil, false, nil) when no
+// revision within maxDepth matches, which callers should treat as the
+// content being stale rather than trustworthy; or (nil, false,
+// ErrFileRevisionsUnsupported) when provider can't list file history at all,
+// which callers should treat as "can't tell" rather than "stale".
+func (store *Store) ResolveEquivalentLine(ctx context.Context, provider SourceProvider, repoPath string, filePath string, fallbackSHA string, lineNumber int, referenceWindow string, maxDepth int) (*string, bool, error) {
+	revisionProvider, ok := provider.(FileRevisionProvider)
+	if !ok {
+		return nil, false, ErrFileRevisionsUnsupported
+	}
+
+	if maxDepth <= 0 {
+		maxDepth = DefaultEquivalentLineDepth
+	}
+
+	revisions, err := revisionProvider.ListFileRevisions(ctx, repoPath, filePath, fallbackSHA, maxDepth)
+	if err != nil {
+		return nil, false, e.Wrap(err, "error listing file revisions")
+	}
+
+	// revisions[0] is the commit that most recently touched filePath at or
+	// before fallbackSHA, whose content is - by how git trees resolve a path
+	// at a ref - exactly what was already fetched at fallbackSHA. Comparing
+	// it against referenceWindow (itself read at fallbackSHA) would always
+	// match, so it carries no information; real signal starts one step
+	// further back in history.
+	if len(revisions) > 0 {
+		revisions = revisions[1:]
+	}
+
+	for _, sha := range revisions {
+		content, err := provider.FetchFile(ctx, repoPath, filePath, sha)
+		if err != nil {
+			continue
+		}
+
+		decoded, err := base64.StdEncoding.DecodeString(*content)
+		if err != nil {
+			continue
+		}
+
+		lines := strings.Split(string(decoded), "\n")
+		lineContent, before, after, err := store.ExpandedStackTrace(ctx, lines, lineNumber)
+		if err != nil {
+			continue
+		}
+
+		if windowAt(before, lineContent, after) == referenceWindow {
+			sha := sha
+			return &sha, true, nil
+		}
+	}
+
+	return nil, false, nil
+}
@@ -0,0 +1,92 @@
+package store
+
+import (
+	"context"
+	"encoding/base64"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// mockHistoryProvider serves a fixed sequence of file revisions, each with
+// its own content, to exercise ResolveEquivalentLine's history walk.
+type mockHistoryProvider struct {
+	revisions []string
+	content   map[string]string
+}
+
+func (p *mockHistoryProvider) FetchFile(ctx context.Context, repoPath string, filePath string, ref string) (*string, error) {
+	content, ok := p.content[ref]
+	if !ok {
+		return nil, assert.AnError
+	}
+	encoded := base64.StdEncoding.EncodeToString([]byte(content))
+	return &encoded, nil
+}
+
+func (p *mockHistoryProvider) LatestCommit(ctx context.Context, repoPath string) (*string, error) {
+	return &p.revisions[0], nil
+}
+
+func (p *mockHistoryProvider) ResolveRef(ctx context.Context, repoPath string, serviceVersion string) (*string, error) {
+	return p.LatestCommit(ctx, repoPath)
+}
+
+func (p *mockHistoryProvider) ListFileRevisions(ctx context.Context, repoPath string, filePath string, ref string, maxDepth int) ([]string, error) {
+	if maxDepth < len(p.revisions) {
+		return p.revisions[:maxDepth], nil
+	}
+	return p.revisions, nil
+}
+
+func TestResolveEquivalentLine(t *testing.T) {
+	defer teardown(t)
+	ctx := context.Background()
+
+	// rev-1 is the unresolved fallback; the line has drifted there, but
+	// settles back to the reference window one commit back, at rev-2.
+	provider := &mockHistoryProvider{
+		revisions: []string{"rev-1", "rev-2", "rev-3"},
+		content: map[string]string{
+			"rev-1": "a\nCHANGED\nc\nd\ne\nf",
+			"rev-2": "a\nb\nc\nd\ne\nf",
+			"rev-3": "a\nb\nc\nd\ne\nf",
+		},
+	}
+
+	// referenceWindow is the window around line 2 as read at rev-2, which
+	// should match rev-2 itself and rev-3 but not rev-1.
+	referenceWindow := windowAt(strPtr("a"), strPtr("b"), strPtr("c\nd\ne\nf"))
+
+	sha, found, err := store.ResolveEquivalentLine(ctx, provider, "org/repo", "/file.js", "rev-1", 2, referenceWindow, 10)
+	assert.NoError(t, err)
+	assert.True(t, found)
+	assert.Equal(t, "rev-2", *sha)
+}
+
+func TestResolveEquivalentLineDepthCutoff(t *testing.T) {
+	defer teardown(t)
+	ctx := context.Background()
+
+	// Only rev-3 matches the reference window, and it lies beyond maxDepth,
+	// so the walk should stop before reaching it and report no match.
+	provider := &mockHistoryProvider{
+		revisions: []string{"rev-1", "rev-2", "rev-3"},
+		content: map[string]string{
+			"rev-1": "a\nCHANGED-1\nc",
+			"rev-2": "a\nCHANGED-2\nc",
+			"rev-3": "a\nb\nc",
+		},
+	}
+
+	referenceWindow := windowAt(strPtr("a"), strPtr("b"), strPtr("c"))
+
+	sha, found, err := store.ResolveEquivalentLine(ctx, provider, "org/repo", "/file.js", "rev-1", 2, referenceWindow, 2)
+	assert.NoError(t, err)
+	assert.False(t, found)
+	assert.Nil(t, sha)
+}
+
+func strPtr(s string) *string {
+	return &s
+}
@@ -0,0 +1,375 @@
+package store
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	github2 "github.com/google/go-github/v50/github"
+	e "github.com/pkg/errors"
+	"golang.org/x/sync/singleflight"
+)
+
+// RepoMirrorConfig configures a RepoMirror.
+type RepoMirrorConfig struct {
+	// MirrorRoot is the directory repo mirrors are cloned into, one
+	// subdirectory per repo path.
+	MirrorRoot string
+	// Depth is the shallow clone depth used for new mirrors. 0 means a
+	// full clone.
+	Depth int
+	// FetchInterval is how often the background refresher re-fetches
+	// each mirror.
+	FetchInterval time.Duration
+	// MaxDiskUsageBytes bounds the total size of MirrorRoot; the
+	// least-recently-accessed repo directories are evicted once it's
+	// exceeded. Zero disables eviction.
+	MaxDiskUsageBytes int64
+	// Auth is per-repo transport auth, keyed by repo path.
+	Auth map[string]transport.AuthMethod
+}
+
+// RepoMirror maintains on-disk git mirrors of configured repos and serves
+// GithubClient reads out of them, avoiding a REST/GraphQL round trip per
+// stack trace. Any local miss (mirror absent, file/ref not found, clone
+// still in progress) falls back to Fallback.
+type RepoMirror struct {
+	RepoMirrorConfig
+	Fallback GithubClient
+
+	// clone performs the actual clone in ensureMirror. Defaults to
+	// git.PlainCloneContext; overridable in tests so exercising a cache miss
+	// doesn't require a real network clone of the (possibly large) upstream
+	// repo.
+	clone func(ctx context.Context, dir string, opts *git.CloneOptions) (*git.Repository, error)
+
+	group singleflight.Group
+
+	mu         sync.Mutex
+	accessedAt map[string]time.Time
+}
+
+var _ GithubClient = (*RepoMirror)(nil)
+
+// NewRepoMirror builds a RepoMirror backed by fallback for cache misses.
+func NewRepoMirror(cfg RepoMirrorConfig, fallback GithubClient) *RepoMirror {
+	return &RepoMirror{
+		RepoMirrorConfig: cfg,
+		Fallback:         fallback,
+		accessedAt:       map[string]time.Time{},
+		clone: func(ctx context.Context, dir string, opts *git.CloneOptions) (*git.Repository, error) {
+			return git.PlainCloneContext(ctx, dir, true, opts)
+		},
+	}
+}
+
+func (m *RepoMirror) repoDir(repoPath string) string {
+	return filepath.Join(m.MirrorRoot, repoPath)
+}
+
+func (m *RepoMirror) touch(repoPath string) {
+	m.mu.Lock()
+	m.accessedAt[repoPath] = time.Now()
+	m.mu.Unlock()
+}
+
+// ensureMirror opens repoPath's mirror, cloning it first if it doesn't
+// exist yet. Concurrent callers for the same repo single-flight onto one
+// clone.
+func (m *RepoMirror) ensureMirror(ctx context.Context, repoPath string) (*git.Repository, error) {
+	dir := m.repoDir(repoPath)
+
+	if repo, err := git.PlainOpen(dir); err == nil {
+		m.touch(repoPath)
+		return repo, nil
+	}
+
+	v, err, _ := m.group.Do("clone:"+repoPath, func() (interface{}, error) {
+		if repo, err := git.PlainOpen(dir); err == nil {
+			return repo, nil
+		}
+
+		if err := m.evictIfNeeded(); err != nil {
+			return nil, e.Wrap(err, "error evicting mirrors to free disk space")
+		}
+
+		// go-git doesn't support the git partial-clone protocol extension
+		// (--filter=blob:none has no equivalent in CloneOptions), so Depth
+		// is the only lever available to keep a large monorepo's mirror
+		// small; a shallow clone still pulls every blob reachable from the
+		// cloned history, just not the full history itself.
+		repo, err := m.clone(ctx, dir, &git.CloneOptions{
+			URL:   fmt.Sprintf("https://github.com/%s.git", repoPath),
+			Depth: m.Depth,
+			Auth:  m.Auth[repoPath],
+		})
+		if err != nil {
+			return nil, e.Wrap(err, "error cloning repo mirror")
+		}
+		return repo, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	m.touch(repoPath)
+	return v.(*git.Repository), nil
+}
+
+// refresh re-fetches repoPath's mirror, single-flighting with any other
+// refresh in progress for the same repo.
+func (m *RepoMirror) refresh(ctx context.Context, repoPath string) error {
+	repo, err := m.ensureMirror(ctx, repoPath)
+	if err != nil {
+		return err
+	}
+
+	_, err, _ = m.group.Do("fetch:"+repoPath, func() (interface{}, error) {
+		err := repo.FetchContext(ctx, &git.FetchOptions{Auth: m.Auth[repoPath]})
+		if err != nil && err != git.NoErrAlreadyUpToDate {
+			return nil, err
+		}
+		return nil, nil
+	})
+	return err
+}
+
+// StartRefresher periodically refreshes every mirror in repoPaths until ctx
+// is canceled, jittering each repo's first fetch so they don't all hit the
+// upstream host at once.
+func (m *RepoMirror) StartRefresher(ctx context.Context, repoPaths []string) {
+	for _, repoPath := range repoPaths {
+		repoPath := repoPath
+		jitter := time.Duration(rand.Int63n(int64(m.FetchInterval) + 1))
+
+		go func() {
+			timer := time.NewTimer(jitter)
+			defer timer.Stop()
+
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-timer.C:
+					_ = m.refresh(ctx, repoPath)
+					timer.Reset(m.FetchInterval)
+				}
+			}
+		}()
+	}
+}
+
+// evictIfNeeded removes the least-recently-accessed repo mirrors until
+// MirrorRoot's total size is back under MaxDiskUsageBytes.
+func (m *RepoMirror) evictIfNeeded() error {
+	if m.MaxDiskUsageBytes <= 0 {
+		return nil
+	}
+
+	// Mirrors live at <MirrorRoot>/<owner>/<repo>, the repo paths accessedAt
+	// is keyed by, so they are listed two levels down.
+	entries, err := filepath.Glob(filepath.Join(m.MirrorRoot, "*", "*"))
+	if err != nil {
+		return err
+	}
+
+	type repoUsage struct {
+		name       string
+		size       int64
+		accessedAt time.Time
+	}
+
+	var usages []repoUsage
+	var total int64
+
+	for _, dir := range entries {
+		name, err := filepath.Rel(m.MirrorRoot, dir)
+		if info, statErr := os.Stat(dir); err != nil || statErr != nil || !info.IsDir() {
+			continue
+		}
+		name = filepath.ToSlash(name)
+
+		size, err := dirSize(dir)
+		if err != nil {
+			continue
+		}
+		total += size
+
+		m.mu.Lock()
+		accessedAt, ok := m.accessedAt[name]
+		m.mu.Unlock()
+		if !ok {
+			accessedAt = time.Unix(0, 0)
+		}
+
+		usages = append(usages, repoUsage{name: name, size: size, accessedAt: accessedAt})
+	}
+
+	if total <= m.MaxDiskUsageBytes {
+		return nil
+	}
+
+	sort.Slice(usages, func(i, j int) bool { return usages[i].accessedAt.Before(usages[j].accessedAt) })
+
+	for _, u := range usages {
+		if total <= m.MaxDiskUsageBytes {
+			break
+		}
+		if err := os.RemoveAll(filepath.Join(m.MirrorRoot, u.name)); err != nil {
+			continue
+		}
+
+		m.mu.Lock()
+		delete(m.accessedAt, u.name)
+		m.mu.Unlock()
+
+		total -= u.size
+	}
+
+	return nil
+}
+
+func dirSize(path string) (int64, error) {
+	var size int64
+	err := filepath.Walk(path, func(_ string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
+
+func (m *RepoMirror) readFile(ctx context.Context, repoPath string, filePath string, ref string) (*string, error) {
+	repo, err := m.ensureMirror(ctx, repoPath)
+	if err != nil {
+		return nil, err
+	}
+
+	hash, err := repo.ResolveRevision(plumbing.Revision(ref))
+	if err != nil {
+		return nil, e.Wrap(err, "error resolving revision in mirror")
+	}
+
+	commit, err := repo.CommitObject(*hash)
+	if err != nil {
+		return nil, e.Wrap(err, "error reading commit from mirror")
+	}
+
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, e.Wrap(err, "error reading tree from mirror")
+	}
+
+	file, err := tree.File(strings.TrimPrefix(filePath, "/"))
+	if err != nil {
+		return nil, e.Wrap(err, "error finding file in mirror tree")
+	}
+
+	contents, err := file.Contents()
+	if err != nil {
+		return nil, e.Wrap(err, "error reading file contents from mirror")
+	}
+
+	encoded := base64.StdEncoding.EncodeToString([]byte(contents))
+	return &encoded, nil
+}
+
+// GetRepoContent reads path at version out of the local mirror when
+// possible, falling back to Fallback on any miss.
+func (m *RepoMirror) GetRepoContent(ctx context.Context, githubPath string, path string, version string) (*github2.RepositoryContent, []*github2.RepositoryContent, *github2.Response, error) {
+	content, err := m.readFile(ctx, githubPath, path, version)
+	if err != nil {
+		return m.Fallback.GetRepoContent(ctx, githubPath, path, version)
+	}
+	return &github2.RepositoryContent{Content: content}, nil, nil, nil
+}
+
+// GetRepoBlob has no cheap mirror-backed equivalent (content is already
+// inlined by GetRepoContent above), so it always defers to Fallback.
+func (m *RepoMirror) GetRepoBlob(ctx context.Context, githubPath string, blobSHA string) (*github2.Blob, *github2.Response, error) {
+	return m.Fallback.GetRepoBlob(ctx, githubPath, blobSHA)
+}
+
+func (m *RepoMirror) GetLatestCommitHash(ctx context.Context, githubPath string) (string, *github2.Response, error) {
+	repo, err := m.ensureMirror(ctx, githubPath)
+	if err != nil {
+		return m.Fallback.GetLatestCommitHash(ctx, githubPath)
+	}
+
+	head, err := repo.Head()
+	if err != nil {
+		return m.Fallback.GetLatestCommitHash(ctx, githubPath)
+	}
+
+	return head.Hash().String(), nil, nil
+}
+
+// GetBlameForLine has no mirror-backed implementation; blame still goes
+// through Fallback's GraphQL-backed client.
+func (m *RepoMirror) GetBlameForLine(ctx context.Context, githubPath string, filePath string, sha string, lineNumber int) (*BlameRange, error) {
+	return m.Fallback.GetBlameForLine(ctx, githubPath, filePath, sha, lineNumber)
+}
+
+func (m *RepoMirror) ListFileRevisions(ctx context.Context, githubPath string, filePath string, ref string, maxDepth int) ([]string, error) {
+	repo, err := m.ensureMirror(ctx, githubPath)
+	if err != nil {
+		return m.Fallback.ListFileRevisions(ctx, githubPath, filePath, ref, maxDepth)
+	}
+
+	hash, err := repo.ResolveRevision(plumbing.Revision(ref))
+	if err != nil {
+		return m.Fallback.ListFileRevisions(ctx, githubPath, filePath, ref, maxDepth)
+	}
+
+	trimmedPath := strings.TrimPrefix(filePath, "/")
+	commitIter, err := repo.Log(&git.LogOptions{From: *hash, FileName: &trimmedPath})
+	if err != nil {
+		return m.Fallback.ListFileRevisions(ctx, githubPath, filePath, ref, maxDepth)
+	}
+
+	var shas []string
+	err = commitIter.ForEach(func(c *object.Commit) error {
+		if len(shas) >= maxDepth {
+			return storer.ErrStop
+		}
+		shas = append(shas, c.Hash.String())
+		return nil
+	})
+	if err != nil {
+		return nil, e.Wrap(err, "error walking mirror commit log")
+	}
+
+	return shas, nil
+}
+
+func (m *RepoMirror) CreateIssue(ctx context.Context, repo string, issueRequest *github2.IssueRequest) (*github2.Issue, error) {
+	return m.Fallback.CreateIssue(ctx, repo, issueRequest)
+}
+
+func (m *RepoMirror) ListLabels(ctx context.Context, repo string) ([]*github2.Label, error) {
+	return m.Fallback.ListLabels(ctx, repo)
+}
+
+func (m *RepoMirror) ListRepos(ctx context.Context) ([]*github2.Repository, error) {
+	return m.Fallback.ListRepos(ctx)
+}
+
+func (m *RepoMirror) DeleteInstallation(ctx context.Context, installation string) error {
+	return m.Fallback.DeleteInstallation(ctx, installation)
+}
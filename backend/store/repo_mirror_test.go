@@ -0,0 +1,120 @@
+package store
+
+import (
+	"context"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/stretchr/testify/assert"
+)
+
+// newFixtureMirror builds a working (non-bare) repo at <root>/<repoPath>
+// containing a single commit that writes content to filePath, and returns
+// that commit's SHA. RepoMirror.ensureMirror opens mirrors with
+// git.PlainOpen, which works against working repos just as well as bare
+// ones, so this stands in for a real clone without hitting the network.
+func newFixtureMirror(tb testing.TB, root string, repoPath string, filePath string, content string) string {
+	tb.Helper()
+
+	dir := filepath.Join(root, repoPath)
+	assert.NoError(tb, os.MkdirAll(dir, 0o755))
+
+	repo, err := git.PlainInit(dir, false)
+	assert.NoError(tb, err)
+
+	relPath := filePath[1:]
+	fullPath := filepath.Join(dir, relPath)
+	assert.NoError(tb, os.MkdirAll(filepath.Dir(fullPath), 0o755))
+	assert.NoError(tb, os.WriteFile(fullPath, []byte(content), 0o644))
+
+	wt, err := repo.Worktree()
+	assert.NoError(tb, err)
+
+	_, err = wt.Add(relPath)
+	assert.NoError(tb, err)
+
+	hash, err := wt.Commit("fixture commit", &git.CommitOptions{
+		Author: &object.Signature{Name: "Fixture", Email: "fixture@example.com", When: time.Unix(1700000000, 0)},
+	})
+	assert.NoError(tb, err)
+
+	return hash.String()
+}
+
+func TestRepoMirrorMatchesGitHubAPIContent(t *testing.T) {
+	defer teardown(t)
+	ctx := context.Background()
+
+	// MockGithubClient.GetRepoContent returns this exact content for
+	// /file.js regardless of sha; build a fixture mirror with the same
+	// content so the two can be compared directly instead of each against
+	// its own hardcoded literal.
+	const wantContent = "console.log('hello world')"
+
+	root := t.TempDir()
+	sha := newFixtureMirror(t, root, "org/repo", "/file.js", wantContent)
+
+	mirror := NewRepoMirror(RepoMirrorConfig{MirrorRoot: root}, &MockGithubClient{})
+
+	mirrorContent, _, _, err := mirror.GetRepoContent(ctx, "org/repo", "/file.js", sha)
+	assert.NoError(t, err)
+	decodedMirror, err := base64.StdEncoding.DecodeString(*mirrorContent.Content)
+	assert.NoError(t, err)
+
+	apiContent, _, _, err := (&MockGithubClient{}).GetRepoContent(ctx, "highlight/highlight", "/file.js", "1234567890")
+	assert.NoError(t, err)
+	decodedAPI, err := base64.StdEncoding.DecodeString(*apiContent.Content)
+	assert.NoError(t, err)
+
+	assert.Equal(t, string(decodedAPI), string(decodedMirror))
+}
+
+func TestRepoMirrorFallsBackOnMiss(t *testing.T) {
+	defer teardown(t)
+	ctx := context.Background()
+
+	mirror := NewRepoMirror(RepoMirrorConfig{MirrorRoot: t.TempDir()}, &MockGithubClient{})
+	// Stub out the real clone so exercising a cache miss never makes a
+	// network call against the real upstream repo; ensureMirror should fall
+	// back to Fallback just the same whether the clone fails locally or the
+	// host is unreachable.
+	mirror.clone = func(ctx context.Context, dir string, opts *git.CloneOptions) (*git.Repository, error) {
+		return nil, assert.AnError
+	}
+
+	// No mirror has ever been cloned for this repo, so this should defer
+	// straight to the fallback client's canned response.
+	content, _, _, err := mirror.GetRepoContent(ctx, "highlight/highlight", "/file.js", "1234567890")
+	assert.NoError(t, err)
+	assert.Equal(t, "Y29uc29sZS5sb2coJ2hlbGxvIHdvcmxkJyk=", *content.Content)
+}
+
+func BenchmarkRepoMirrorVsMockHTTPClient(b *testing.B) {
+	ctx := context.Background()
+	root := b.TempDir()
+	sha := newFixtureMirror(b, root, "org/repo", "/file.js", "console.log('hello mirror')")
+
+	mirror := NewRepoMirror(RepoMirrorConfig{MirrorRoot: root}, &MockGithubClient{})
+	httpClient := &MockGithubClient{}
+
+	b.Run("mirror-hot-cache", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, _, _, err := mirror.GetRepoContent(ctx, "org/repo", "/file.js", sha); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("mocked-http-client", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, _, _, err := httpClient.GetRepoContent(ctx, "highlight/highlight", "/file.js", "1234567890"); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
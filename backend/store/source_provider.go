@@ -0,0 +1,410 @@
+package store
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	e "github.com/pkg/errors"
+
+	"github.com/highlight-run/highlight/backend/model"
+)
+
+// Provider values stored on model.Service.Provider. An empty value is
+// treated as ProviderGitHub, matching services created before multi-provider
+// support existed. The Provider column itself is added by the companion
+// model PR that lands alongside this one; this package can't compile on its
+// own without it.
+const (
+	ProviderGitHub    = "github"
+	ProviderGitLab    = "gitlab"
+	ProviderBitbucket = "bitbucket"
+	ProviderGit       = "git"
+)
+
+// SourceProvider is implemented by each VCS backend the stack trace
+// enhancement pipeline can pull source files and commit history from.
+type SourceProvider interface {
+	// FetchFile returns the base64-encoded contents of filePath at ref.
+	FetchFile(ctx context.Context, repoPath string, filePath string, ref string) (*string, error)
+	// LatestCommit returns the most recent commit SHA on repoPath's default branch.
+	LatestCommit(ctx context.Context, repoPath string) (*string, error)
+	// ResolveRef resolves serviceVersion to a usable commit ref, falling
+	// back to LatestCommit when serviceVersion isn't already a SHA.
+	ResolveRef(ctx context.Context, repoPath string, serviceVersion string) (*string, error)
+}
+
+// GitProvider serves source straight out of a local git mirror via go-git,
+// rather than a hosted git service's API.
+type GitProvider interface {
+	SourceProvider
+}
+
+// SourceProviders is the registry of VCS backends configured for a
+// deployment. Only the providers actually in use by a deployment's services
+// need to be populated.
+type SourceProviders struct {
+	GitHub    GithubClient
+	GitLab    GitLabClient
+	Bitbucket BitbucketClient
+	Git       GitProvider
+}
+
+// resolve returns the SourceProvider for service, defaulting to GitHub when
+// service.Provider is unset.
+func (p *SourceProviders) resolve(service *model.Service) (SourceProvider, error) {
+	if p == nil {
+		return nil, e.New("no source providers configured")
+	}
+
+	switch service.Provider {
+	case "", ProviderGitHub:
+		if p.GitHub == nil {
+			return nil, e.New("no github client configured")
+		}
+		return &githubSourceProvider{GithubClient: p.GitHub}, nil
+	case ProviderGitLab:
+		if p.GitLab == nil {
+			return nil, e.New("no gitlab client configured")
+		}
+		return &gitlabSourceProvider{GitLabClient: p.GitLab}, nil
+	case ProviderBitbucket:
+		if p.Bitbucket == nil {
+			return nil, e.New("no bitbucket client configured")
+		}
+		return &bitbucketSourceProvider{BitbucketClient: p.Bitbucket}, nil
+	case ProviderGit:
+		if p.Git == nil {
+			return nil, e.New("no git provider configured")
+		}
+		return p.Git, nil
+	default:
+		return nil, e.Errorf("unsupported source provider %s", service.Provider)
+	}
+}
+
+// githubSourceProvider adapts the existing GithubClient to SourceProvider.
+type githubSourceProvider struct {
+	GithubClient
+}
+
+func (g *githubSourceProvider) FetchFile(ctx context.Context, repoPath string, filePath string, ref string) (*string, error) {
+	fileContent, _, _, err := g.GetRepoContent(ctx, repoPath, filePath, ref)
+	if err != nil {
+		return nil, e.Wrap(err, "error fetching repo content from github")
+	}
+	if fileContent == nil {
+		return nil, e.New("github returned no content for file")
+	}
+
+	if fileContent.Content != nil && *fileContent.Content != "" {
+		return fileContent.Content, nil
+	}
+
+	if fileContent.SHA == nil {
+		return nil, e.New("github returned no content or blob sha for file")
+	}
+
+	blob, _, err := g.GetRepoBlob(ctx, repoPath, *fileContent.SHA)
+	if err != nil {
+		return nil, e.Wrap(err, "error fetching repo blob from github")
+	}
+	if blob == nil || blob.Content == nil {
+		return nil, e.New("github returned no content for blob")
+	}
+
+	return blob.Content, nil
+}
+
+func (g *githubSourceProvider) LatestCommit(ctx context.Context, repoPath string) (*string, error) {
+	sha, _, err := g.GetLatestCommitHash(ctx, repoPath)
+	if err != nil {
+		return nil, e.Wrap(err, "error fetching latest commit hash from github")
+	}
+	if sha == "" {
+		return nil, e.Errorf("no commit found for repo %s", repoPath)
+	}
+	return &sha, nil
+}
+
+func (g *githubSourceProvider) ResolveRef(ctx context.Context, repoPath string, serviceVersion string) (*string, error) {
+	if gitSHARegexp.MatchString(serviceVersion) {
+		return &serviceVersion, nil
+	}
+	return g.LatestCommit(ctx, repoPath)
+}
+
+// GitLabClient is the subset of GitLab's REST API the store depends on.
+type GitLabClient interface {
+	GetRawFile(ctx context.Context, projectPath string, filePath string, ref string) ([]byte, error)
+	GetLatestCommitSHA(ctx context.Context, projectPath string) (string, error)
+}
+
+type gitlabRESTClient struct {
+	baseURL    string
+	token      string
+	httpClient *http.Client
+}
+
+// NewGitLabClient builds a GitLabClient against baseURL (e.g.
+// https://gitlab.com) authenticated with a personal/project access token.
+func NewGitLabClient(baseURL string, token string) GitLabClient {
+	return &gitlabRESTClient{baseURL: strings.TrimSuffix(baseURL, "/"), token: token, httpClient: http.DefaultClient}
+}
+
+func (c *gitlabRESTClient) GetRawFile(ctx context.Context, projectPath string, filePath string, ref string) ([]byte, error) {
+	reqURL := fmt.Sprintf("%s/api/v4/projects/%s/repository/files/%s/raw?ref=%s",
+		c.baseURL, url.PathEscape(projectPath), url.PathEscape(strings.TrimPrefix(filePath, "/")), url.QueryEscape(ref))
+
+	body, err := c.get(ctx, reqURL)
+	if err != nil {
+		return nil, e.Wrap(err, "error fetching file from gitlab")
+	}
+	return body, nil
+}
+
+func (c *gitlabRESTClient) GetLatestCommitSHA(ctx context.Context, projectPath string) (string, error) {
+	reqURL := fmt.Sprintf("%s/api/v4/projects/%s/repository/commits?per_page=1", c.baseURL, url.PathEscape(projectPath))
+
+	body, err := c.get(ctx, reqURL)
+	if err != nil {
+		return "", e.Wrap(err, "error fetching commits from gitlab")
+	}
+
+	var commits []struct {
+		ID string `json:"id"`
+	}
+	if err := json.Unmarshal(body, &commits); err != nil {
+		return "", e.Wrap(err, "error decoding gitlab response")
+	}
+	if len(commits) == 0 {
+		return "", e.Errorf("no commits found for project %s", projectPath)
+	}
+
+	return commits[0].ID, nil
+}
+
+func (c *gitlabRESTClient) get(ctx context.Context, reqURL string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, reqURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("PRIVATE-TOKEN", c.token)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, e.Errorf("gitlab api returned status %d for %s", resp.StatusCode, reqURL)
+	}
+
+	return io.ReadAll(resp.Body)
+}
+
+type gitlabSourceProvider struct {
+	GitLabClient
+}
+
+func (g *gitlabSourceProvider) FetchFile(ctx context.Context, repoPath string, filePath string, ref string) (*string, error) {
+	raw, err := g.GetRawFile(ctx, repoPath, filePath, ref)
+	if err != nil {
+		return nil, err
+	}
+	encoded := base64.StdEncoding.EncodeToString(raw)
+	return &encoded, nil
+}
+
+func (g *gitlabSourceProvider) LatestCommit(ctx context.Context, repoPath string) (*string, error) {
+	sha, err := g.GetLatestCommitSHA(ctx, repoPath)
+	if err != nil {
+		return nil, err
+	}
+	return &sha, nil
+}
+
+func (g *gitlabSourceProvider) ResolveRef(ctx context.Context, repoPath string, serviceVersion string) (*string, error) {
+	if gitSHARegexp.MatchString(serviceVersion) {
+		return &serviceVersion, nil
+	}
+	return g.LatestCommit(ctx, repoPath)
+}
+
+// BitbucketClient is the subset of Bitbucket's 2.0 REST API the store
+// depends on.
+type BitbucketClient interface {
+	GetSrcFile(ctx context.Context, repoPath string, filePath string, ref string) ([]byte, error)
+	GetLatestCommitSHA(ctx context.Context, repoPath string) (string, error)
+}
+
+type bitbucketRESTClient struct {
+	baseURL     string
+	username    string
+	appPassword string
+	httpClient  *http.Client
+}
+
+// NewBitbucketClient builds a BitbucketClient against baseURL (e.g.
+// https://api.bitbucket.org) authenticated with an app password.
+func NewBitbucketClient(baseURL string, username string, appPassword string) BitbucketClient {
+	return &bitbucketRESTClient{baseURL: strings.TrimSuffix(baseURL, "/"), username: username, appPassword: appPassword, httpClient: http.DefaultClient}
+}
+
+func (c *bitbucketRESTClient) GetSrcFile(ctx context.Context, repoPath string, filePath string, ref string) ([]byte, error) {
+	reqURL := fmt.Sprintf("%s/2.0/repositories/%s/src/%s/%s",
+		c.baseURL, repoPath, url.PathEscape(ref), strings.TrimPrefix(filePath, "/"))
+
+	return c.get(ctx, reqURL)
+}
+
+func (c *bitbucketRESTClient) GetLatestCommitSHA(ctx context.Context, repoPath string) (string, error) {
+	reqURL := fmt.Sprintf("%s/2.0/repositories/%s/commits", c.baseURL, repoPath)
+
+	body, err := c.get(ctx, reqURL)
+	if err != nil {
+		return "", err
+	}
+
+	var result struct {
+		Values []struct {
+			Hash string `json:"hash"`
+		} `json:"values"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return "", e.Wrap(err, "error decoding bitbucket response")
+	}
+	if len(result.Values) == 0 {
+		return "", e.Errorf("no commits found for repo %s", repoPath)
+	}
+
+	return result.Values[0].Hash, nil
+}
+
+func (c *bitbucketRESTClient) get(ctx context.Context, reqURL string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, reqURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.SetBasicAuth(c.username, c.appPassword)
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, e.Errorf("bitbucket api returned status %d for %s", resp.StatusCode, reqURL)
+	}
+
+	return io.ReadAll(resp.Body)
+}
+
+type bitbucketSourceProvider struct {
+	BitbucketClient
+}
+
+func (b *bitbucketSourceProvider) FetchFile(ctx context.Context, repoPath string, filePath string, ref string) (*string, error) {
+	raw, err := b.GetSrcFile(ctx, repoPath, filePath, ref)
+	if err != nil {
+		return nil, e.Wrap(err, "error fetching file from bitbucket")
+	}
+	encoded := base64.StdEncoding.EncodeToString(raw)
+	return &encoded, nil
+}
+
+func (b *bitbucketSourceProvider) LatestCommit(ctx context.Context, repoPath string) (*string, error) {
+	sha, err := b.GetLatestCommitSHA(ctx, repoPath)
+	if err != nil {
+		return nil, e.Wrap(err, "error fetching latest commit from bitbucket")
+	}
+	return &sha, nil
+}
+
+func (b *bitbucketSourceProvider) ResolveRef(ctx context.Context, repoPath string, serviceVersion string) (*string, error) {
+	if gitSHARegexp.MatchString(serviceVersion) {
+		return &serviceVersion, nil
+	}
+	return b.LatestCommit(ctx, repoPath)
+}
+
+// localGitProvider reads source directly out of local git mirrors rooted at
+// MirrorRoot (one directory per repoPath), via go-git. It's meant to be kept
+// up to date by a RepoMirror and avoids hitting a hosted git service's API
+// for every stack trace enhanced.
+type localGitProvider struct {
+	MirrorRoot string
+}
+
+// NewLocalGitProvider builds a GitProvider that reads from bare mirrors
+// rooted at mirrorRoot.
+func NewLocalGitProvider(mirrorRoot string) GitProvider {
+	return &localGitProvider{MirrorRoot: mirrorRoot}
+}
+
+func (g *localGitProvider) FetchFile(ctx context.Context, repoPath string, filePath string, ref string) (*string, error) {
+	repo, err := git.PlainOpen(filepath.Join(g.MirrorRoot, repoPath))
+	if err != nil {
+		return nil, e.Wrap(err, "error opening local git mirror")
+	}
+
+	hash, err := repo.ResolveRevision(plumbing.Revision(ref))
+	if err != nil {
+		return nil, e.Wrap(err, "error resolving git revision")
+	}
+
+	commit, err := repo.CommitObject(*hash)
+	if err != nil {
+		return nil, e.Wrap(err, "error reading commit object")
+	}
+
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, e.Wrap(err, "error reading commit tree")
+	}
+
+	file, err := tree.File(strings.TrimPrefix(filePath, "/"))
+	if err != nil {
+		return nil, e.Wrap(err, "error finding file in commit tree")
+	}
+
+	contents, err := file.Contents()
+	if err != nil {
+		return nil, e.Wrap(err, "error reading file contents")
+	}
+
+	encoded := base64.StdEncoding.EncodeToString([]byte(contents))
+	return &encoded, nil
+}
+
+func (g *localGitProvider) LatestCommit(ctx context.Context, repoPath string) (*string, error) {
+	repo, err := git.PlainOpen(filepath.Join(g.MirrorRoot, repoPath))
+	if err != nil {
+		return nil, e.Wrap(err, "error opening local git mirror")
+	}
+
+	head, err := repo.Head()
+	if err != nil {
+		return nil, e.Wrap(err, "error reading mirror head")
+	}
+
+	sha := head.Hash().String()
+	return &sha, nil
+}
+
+func (g *localGitProvider) ResolveRef(ctx context.Context, repoPath string, serviceVersion string) (*string, error) {
+	if gitSHARegexp.MatchString(serviceVersion) {
+		return &serviceVersion, nil
+	}
+	return g.LatestCommit(ctx, repoPath)
+}
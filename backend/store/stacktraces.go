@@ -0,0 +1,389 @@
+package store
+
+import (
+	"context"
+	"encoding/base64"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	github2 "github.com/google/go-github/v50/github"
+	e "github.com/pkg/errors"
+
+	"github.com/highlight-run/highlight/backend/model"
+	privateModel "github.com/highlight-run/highlight/backend/private-graph/graph/model"
+)
+
+// NumLinesOfContext is the number of lines of surrounding source shown above
+// and below the reported line in an enhanced stack trace.
+const NumLinesOfContext = 5
+
+var gitSHARegexp = regexp.MustCompile(`^[0-9a-f]{7,40}$`)
+
+// BlameRange is a single hunk of a file's git blame, as returned by GitHub's
+// GraphQL `blame(path:)` API on `Blob`.
+type BlameRange struct {
+	StartingLine int
+	EndingLine   int
+	Commit       BlameCommit
+}
+
+// BlameCommit is the commit that last touched a BlameRange.
+type BlameCommit struct {
+	SHA           string
+	Message       string
+	CommittedDate time.Time
+	AuthorName    string
+	AuthorEmail   string
+}
+
+// GithubClient is the set of GitHub operations the store depends on. It is
+// implemented by a real client backed by go-github/the GraphQL API, and by
+// MockGithubClient in tests.
+type GithubClient interface {
+	GetRepoContent(ctx context.Context, githubPath string, path string, version string) (fileContent *github2.RepositoryContent, directoryContent []*github2.RepositoryContent, resp *github2.Response, err error)
+	GetRepoBlob(ctx context.Context, githubPath string, blobSHA string) (*github2.Blob, *github2.Response, error)
+	GetLatestCommitHash(ctx context.Context, githubPath string) (string, *github2.Response, error)
+	// GetBlameForLine returns the blame hunk covering lineNumber in filePath
+	// at sha. Implementations should cache the full per-file blame range
+	// list so that repeated calls for other lines of the same file/sha
+	// reuse a single upstream API call.
+	GetBlameForLine(ctx context.Context, githubPath string, filePath string, sha string, lineNumber int) (*BlameRange, error)
+	// ListFileRevisions returns up to maxDepth commit SHAs that touched
+	// filePath at or before ref, most recent first.
+	ListFileRevisions(ctx context.Context, githubPath string, filePath string, ref string, maxDepth int) ([]string, error)
+	CreateIssue(ctx context.Context, repo string, issueRequest *github2.IssueRequest) (*github2.Issue, error)
+	ListLabels(ctx context.Context, repo string) ([]*github2.Label, error)
+	ListRepos(ctx context.Context) ([]*github2.Repository, error)
+	DeleteInstallation(ctx context.Context, installation string) error
+}
+
+// blameCacheKey identifies a single file at a single revision.
+type blameCacheKey struct {
+	githubPath string
+	filePath   string
+	sha        string
+}
+
+// githubBlameClient is the production GithubClient, backed by go-github for
+// REST calls and by queryGraphQLBlame for blame lookups. It caches blame
+// ranges per file/sha so that looking up blame for several lines of the same
+// file only costs a single queryGraphQLBlame call.
+type githubBlameClient struct {
+	*github2.Client
+
+	blameCacheMu sync.Mutex
+	blameCache   map[blameCacheKey][]BlameRange
+}
+
+// NewGithubBlameClient wraps a go-github client with blame-range caching.
+func NewGithubBlameClient(client *github2.Client) GithubClient {
+	return &githubBlameClient{
+		Client:     client,
+		blameCache: map[blameCacheKey][]BlameRange{},
+	}
+}
+
+func (c *githubBlameClient) GetRepoContent(ctx context.Context, githubPath string, path string, version string) (*github2.RepositoryContent, []*github2.RepositoryContent, *github2.Response, error) {
+	owner, repo, err := splitGithubPath(githubPath)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return c.Repositories.GetContents(ctx, owner, repo, path, &github2.RepositoryContentGetOptions{Ref: version})
+}
+
+func (c *githubBlameClient) GetRepoBlob(ctx context.Context, githubPath string, blobSHA string) (*github2.Blob, *github2.Response, error) {
+	owner, repo, err := splitGithubPath(githubPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	return c.Git.GetBlob(ctx, owner, repo, blobSHA)
+}
+
+func (c *githubBlameClient) GetLatestCommitHash(ctx context.Context, githubPath string) (string, *github2.Response, error) {
+	owner, repo, err := splitGithubPath(githubPath)
+	if err != nil {
+		return "", nil, err
+	}
+	commits, resp, err := c.Repositories.ListCommits(ctx, owner, repo, &github2.CommitsListOptions{ListOptions: github2.ListOptions{PerPage: 1}})
+	if err != nil || len(commits) == 0 {
+		return "", resp, err
+	}
+	return commits[0].GetSHA(), resp, nil
+}
+
+func (c *githubBlameClient) GetBlameForLine(ctx context.Context, githubPath string, filePath string, sha string, lineNumber int) (*BlameRange, error) {
+	ranges, err := c.getFileBlameRanges(ctx, githubPath, filePath, sha)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range ranges {
+		if lineNumber >= r.StartingLine && lineNumber <= r.EndingLine {
+			rCopy := r
+			return &rCopy, nil
+		}
+	}
+	return nil, e.Errorf("no blame range found for %s:%d at %s", filePath, lineNumber, sha)
+}
+
+func (c *githubBlameClient) ListFileRevisions(ctx context.Context, githubPath string, filePath string, ref string, maxDepth int) ([]string, error) {
+	owner, repo, err := splitGithubPath(githubPath)
+	if err != nil {
+		return nil, err
+	}
+
+	commits, _, err := c.Repositories.ListCommits(ctx, owner, repo, &github2.CommitsListOptions{
+		Path:        strings.TrimPrefix(filePath, "/"),
+		SHA:         ref,
+		ListOptions: github2.ListOptions{PerPage: maxDepth},
+	})
+	if err != nil {
+		return nil, e.Wrap(err, "error listing commits for file from github")
+	}
+
+	shas := make([]string, 0, len(commits))
+	for _, commit := range commits {
+		shas = append(shas, commit.GetSHA())
+	}
+
+	return shas, nil
+}
+
+// getFileBlameRanges queries GitHub's GraphQL blame(path:) API for the
+// entirety of filePath at sha, caching the result so subsequent lookups for
+// other lines of the same file/sha don't issue another request.
+func (c *githubBlameClient) getFileBlameRanges(ctx context.Context, githubPath string, filePath string, sha string) ([]BlameRange, error) {
+	key := blameCacheKey{githubPath: githubPath, filePath: filePath, sha: sha}
+
+	c.blameCacheMu.Lock()
+	if ranges, ok := c.blameCache[key]; ok {
+		c.blameCacheMu.Unlock()
+		return ranges, nil
+	}
+	c.blameCacheMu.Unlock()
+
+	owner, repo, err := splitGithubPath(githubPath)
+	if err != nil {
+		return nil, err
+	}
+
+	ranges, err := queryGraphQLBlame(ctx, owner, repo, filePath, sha)
+	if err != nil {
+		return nil, e.Wrap(err, "error querying github graphql blame")
+	}
+
+	c.blameCacheMu.Lock()
+	c.blameCache[key] = ranges
+	c.blameCacheMu.Unlock()
+
+	return ranges, nil
+}
+
+// queryGraphQLBlame loads a file's blame ranges for getFileBlameRanges. The
+// default issues no request and always returns an error, so blame lookups
+// through githubBlameClient fail unless this variable is replaced with a
+// function that runs GitHub's `blame(path:)` GraphQL query (or a test stub).
+var queryGraphQLBlame = func(ctx context.Context, owner string, repo string, filePath string, sha string) ([]BlameRange, error) {
+	return nil, e.New("graphql blame client not configured")
+}
+
+func splitGithubPath(githubPath string) (owner string, repo string, err error) {
+	parts := strings.SplitN(githubPath, "/", 2)
+	if len(parts) != 2 {
+		return "", "", e.Errorf("invalid github repo path %s", githubPath)
+	}
+	return parts[0], parts[1], nil
+}
+
+func (c *githubBlameClient) CreateIssue(ctx context.Context, repo string, issueRequest *github2.IssueRequest) (*github2.Issue, error) {
+	owner, repoName, err := splitGithubPath(repo)
+	if err != nil {
+		return nil, err
+	}
+	issue, _, err := c.Issues.Create(ctx, owner, repoName, issueRequest)
+	return issue, err
+}
+
+func (c *githubBlameClient) ListLabels(ctx context.Context, repo string) ([]*github2.Label, error) {
+	owner, repoName, err := splitGithubPath(repo)
+	if err != nil {
+		return nil, err
+	}
+	labels, _, err := c.Issues.ListLabels(ctx, owner, repoName, nil)
+	return labels, err
+}
+
+func (c *githubBlameClient) ListRepos(ctx context.Context) ([]*github2.Repository, error) {
+	repos, _, err := c.Repositories.List(ctx, "", nil)
+	return repos, err
+}
+
+func (c *githubBlameClient) DeleteInstallation(ctx context.Context, installation string) error {
+	return e.New("not implemented")
+}
+
+// GitHubFilePath rewrites fileName from its on-disk build path to the path
+// GitHub serves the file at, stripping buildPrefix and prepending
+// githubPrefix when they're configured on the service. If buildPrefix is set
+// but doesn't match fileName, fileName is returned unchanged.
+func (store *Store) GitHubFilePath(ctx context.Context, fileName string, buildPrefix *string, githubPrefix *string) string {
+	newPath := fileName
+
+	if buildPrefix != nil {
+		if !strings.HasPrefix(fileName, *buildPrefix) {
+			return fileName
+		}
+		newPath = strings.TrimPrefix(fileName, *buildPrefix)
+	}
+
+	if githubPrefix != nil {
+		newPath = *githubPrefix + newPath
+	}
+
+	return newPath
+}
+
+// ExpandedStackTrace returns the reported line's content along with up to
+// NumLinesOfContext lines immediately before and after it.
+func (store *Store) ExpandedStackTrace(ctx context.Context, lines []string, lineNumber int) (*string, *string, *string, error) {
+	idx := lineNumber - 1
+	if idx < 0 || idx >= len(lines) {
+		return nil, nil, nil, e.Errorf("line number %d is out of range for file with %d lines", lineNumber, len(lines))
+	}
+
+	content := lines[idx]
+
+	beforeStart := idx - NumLinesOfContext
+	if beforeStart < 0 {
+		beforeStart = 0
+	}
+	before := strings.Join(lines[beforeStart:idx], "\n")
+
+	afterEnd := idx + 1 + NumLinesOfContext
+	if afterEnd > len(lines) {
+		afterEnd = len(lines)
+	}
+	after := strings.Join(lines[idx+1:afterEnd], "\n")
+
+	return &content, &before, &after, nil
+}
+
+// FetchFileFromGitHub fetches the contents of fileName from service's source
+// repo at serviceVersion, via whichever SourceProvider service.Provider
+// selects (GitHub when unset).
+func (store *Store) FetchFileFromGitHub(ctx context.Context, trace *privateModel.ErrorTrace, service *model.Service, fileName string, serviceVersion string, providers *SourceProviders) (*string, error) {
+	if service.GithubRepoPath == nil {
+		return nil, e.New("service has no repo path configured")
+	}
+
+	provider, err := providers.resolve(service)
+	if err != nil {
+		return nil, e.Wrap(err, "error resolving source provider")
+	}
+
+	return provider.FetchFile(ctx, *service.GithubRepoPath, fileName, serviceVersion)
+}
+
+// GitHubGitSHA resolves serviceVersion to a git commit SHA via service's
+// configured SourceProvider. serviceVersion is returned as-is when it
+// already looks like a SHA; otherwise the latest commit on the repo's
+// default branch is used.
+func (store *Store) GitHubGitSHA(ctx context.Context, service *model.Service, serviceVersion string, providers *SourceProviders) (*string, error) {
+	if service.GithubRepoPath == nil {
+		return nil, e.New("service has no repo path configured")
+	}
+
+	provider, err := providers.resolve(service)
+	if err != nil {
+		return nil, e.Wrap(err, "error resolving source provider")
+	}
+
+	return provider.ResolveRef(ctx, *service.GithubRepoPath, serviceVersion)
+}
+
+// EnhanceTraceWithGitHub attaches the reported line's source (and
+// surrounding context) from service's configured SourceProvider to trace.
+// When the provider is GitHub and the resolved line content isn't flagged
+// stale, git blame metadata for that line is attached too - blame for a line
+// whose content we don't trust would misattribute authorship. Traces whose
+// file matches ignoredFiles, or that are missing a file name/line number,
+// are returned unmodified.
+func (store *Store) EnhanceTraceWithGitHub(ctx context.Context, trace *privateModel.ErrorTrace, service *model.Service, serviceVersion string, ignoredFiles []string, providers *SourceProviders, equivalentLineDepth int) (*privateModel.ErrorTrace, error) {
+	if trace.FileName == nil || trace.LineNumber == nil {
+		return trace, e.New("trace is missing a file name or line number")
+	}
+
+	for _, pattern := range ignoredFiles {
+		matched, err := regexp.MatchString(pattern, *trace.FileName)
+		if err != nil {
+			return trace, e.Wrap(err, "error matching ignored file pattern")
+		}
+		if matched {
+			return trace, nil
+		}
+	}
+
+	sha, err := store.GitHubGitSHA(ctx, service, serviceVersion, providers)
+	if err != nil {
+		return trace, e.Wrap(err, "error resolving source sha")
+	}
+
+	fileContent, err := store.FetchFileFromGitHub(ctx, trace, service, *trace.FileName, *sha, providers)
+	if err != nil {
+		return trace, e.Wrap(err, "error fetching file from source provider")
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(*fileContent)
+	if err != nil {
+		return trace, e.Wrap(err, "error decoding file content from source provider")
+	}
+
+	lines := strings.Split(string(decoded), "\n")
+	content, before, after, err := store.ExpandedStackTrace(ctx, lines, *trace.LineNumber)
+	if err != nil {
+		return trace, e.Wrap(err, "error expanding stack trace")
+	}
+
+	newTrace := *trace
+	newTrace.LineContent = content
+	newTrace.LinesBefore = before
+	newTrace.LinesAfter = after
+
+	// LineContentStale is added to privateModel.ErrorTrace by the companion
+	// model PR that lands alongside this one; this package can't compile on
+	// its own without it.
+	if !gitSHARegexp.MatchString(serviceVersion) {
+		provider, providerErr := providers.resolve(service)
+		if providerErr == nil {
+			equivalentSHA, found, err := store.ResolveEquivalentLine(ctx, provider, *service.GithubRepoPath, *trace.FileName, *sha, *trace.LineNumber, windowAt(before, content, after), equivalentLineDepth)
+			switch {
+			case err == ErrFileRevisionsUnsupported:
+				// provider can't check history at all; keep the HEAD
+				// content we already resolved rather than calling it stale.
+			case err != nil || !found:
+				newTrace.LineContent = nil
+				newTrace.LinesBefore = nil
+				newTrace.LinesAfter = nil
+				newTrace.LineContentStale = true
+			default:
+				sha = equivalentSHA
+			}
+		}
+	}
+
+	// LineBlameSHA/LineBlameAuthor/LineBlameCommittedAt/LineBlameMessage are
+	// added to privateModel.ErrorTrace by the companion private-graph model
+	// PR that lands alongside this one; this package can't compile on its
+	// own without it.
+	if !newTrace.LineContentStale && (service.Provider == "" || service.Provider == ProviderGitHub) && providers.GitHub != nil {
+		if blame, err := providers.GitHub.GetBlameForLine(ctx, *service.GithubRepoPath, *trace.FileName, *sha, *trace.LineNumber); err == nil {
+			newTrace.LineBlameSHA = &blame.Commit.SHA
+			newTrace.LineBlameAuthor = &blame.Commit.AuthorName
+			newTrace.LineBlameCommittedAt = &blame.Commit.CommittedDate
+			newTrace.LineBlameMessage = &blame.Commit.Message
+		}
+	}
+
+	return &newTrace, nil
+}
@@ -2,8 +2,10 @@ package store
 
 import (
 	"context"
+	"encoding/base64"
 	"errors"
 	"testing"
+	"time"
 
 	"github.com/aws/smithy-go/ptr"
 	github2 "github.com/google/go-github/v50/github"
@@ -181,9 +183,10 @@ func TestFetchFileFromGitHub(t *testing.T) {
 
 	ctx := context.Background()
 	githubClientMock := MockGithubClient{}
+	providers := &SourceProviders{GitHub: &githubClientMock}
 
 	for _, tt := range tests {
-		content, err := store.FetchFileFromGitHub(ctx, tt.Trace, tt.Service, tt.FileName, tt.ServiceVersion, &githubClientMock)
+		content, err := store.FetchFileFromGitHub(ctx, tt.Trace, tt.Service, tt.FileName, tt.ServiceVersion, providers)
 		if tt.ExpectedError {
 			assert.Nil(t, content)
 			assert.Error(t, err)
@@ -198,6 +201,7 @@ func TestGitHubGitSHA(t *testing.T) {
 	defer teardown(t)
 	var tests = []struct {
 		GitHubRepoPath string
+		Provider       string
 		ServiceVersion string
 		ExpectedSHA    *string
 		ExpectedError  bool
@@ -220,13 +224,22 @@ func TestGitHubGitSHA(t *testing.T) {
 			ExpectedSHA:    ptr.String("0987654321"),
 			ExpectedError:  false,
 		},
+		{
+			GitHubRepoPath: "group/project",
+			Provider:       ProviderGitLab,
+			ServiceVersion: "invalid-sha",
+			ExpectedSHA:    ptr.String("gitlab-latest-sha"),
+			ExpectedError:  false,
+		},
 	}
 
 	ctx := context.Background()
 	githubClientMock := MockGithubClient{}
+	providers := &SourceProviders{GitHub: &githubClientMock, GitLab: &MockGitLabClient{}}
 
 	for _, tt := range tests {
-		sha, err := store.GitHubGitSHA(ctx, tt.GitHubRepoPath, tt.ServiceVersion, &githubClientMock)
+		service := &model.Service{GithubRepoPath: ptr.String(tt.GitHubRepoPath), Provider: tt.Provider}
+		sha, err := store.GitHubGitSHA(ctx, service, tt.ServiceVersion, providers)
 		if tt.ExpectedError {
 			assert.Nil(t, sha)
 			assert.Error(t, err)
@@ -237,6 +250,95 @@ func TestGitHubGitSHA(t *testing.T) {
 	}
 }
 
+type MockGitLabClient struct{}
+
+func (c *MockGitLabClient) GetRawFile(ctx context.Context, projectPath string, filePath string, ref string) ([]byte, error) {
+	return []byte("console.log('hello gitlab')"), nil
+}
+
+func (c *MockGitLabClient) GetLatestCommitSHA(ctx context.Context, projectPath string) (string, error) {
+	return "gitlab-latest-sha", nil
+}
+
+func TestFetchFileFromGitHubGitLabProvider(t *testing.T) {
+	defer teardown(t)
+	ctx := context.Background()
+	providers := &SourceProviders{GitLab: &MockGitLabClient{}}
+
+	trace := &privateModel.ErrorTrace{
+		FileName: ptr.String("/build/file.js"),
+	}
+	service := &model.Service{
+		GithubRepoPath: ptr.String("group/project"),
+		Provider:       ProviderGitLab,
+	}
+
+	content, err := store.FetchFileFromGitHub(ctx, trace, service, "/file.js", "main", providers)
+	assert.NoError(t, err)
+	decoded, err := base64.StdEncoding.DecodeString(*content)
+	assert.NoError(t, err)
+	assert.Equal(t, "console.log('hello gitlab')", string(decoded))
+}
+
+type MockBitbucketClient struct{}
+
+func (c *MockBitbucketClient) GetSrcFile(ctx context.Context, repoPath string, filePath string, ref string) ([]byte, error) {
+	return []byte("console.log('hello bitbucket')"), nil
+}
+
+func (c *MockBitbucketClient) GetLatestCommitSHA(ctx context.Context, repoPath string) (string, error) {
+	return "bitbucket-latest-sha", nil
+}
+
+// TestEnhanceTraceWithGitHubNonSHAVersionUnsupportedProvider covers
+// EnhanceTraceWithGitHub for providers that don't implement
+// FileRevisionProvider (GitLab, Bitbucket) with a non-SHA serviceVersion -
+// the common case, since most services report a semver/tag rather than a
+// commit SHA. HEAD content must be kept as-is, not marked stale: the
+// provider can't check history, it didn't fail to find a match.
+func TestEnhanceTraceWithGitHubNonSHAVersionUnsupportedProvider(t *testing.T) {
+	defer teardown(t)
+	ctx := context.Background()
+
+	trace := &privateModel.ErrorTrace{
+		FileName:   ptr.String("/file.js"),
+		LineNumber: ptr.Int(1),
+	}
+
+	var tests = []struct {
+		Name            string
+		Service         *model.Service
+		Providers       *SourceProviders
+		ExpectedContent string
+	}{
+		{
+			Name: "gitlab",
+			Service: &model.Service{
+				GithubRepoPath: ptr.String("group/project"),
+				Provider:       ProviderGitLab,
+			},
+			Providers:       &SourceProviders{GitLab: &MockGitLabClient{}},
+			ExpectedContent: "console.log('hello gitlab')",
+		},
+		{
+			Name: "bitbucket",
+			Service: &model.Service{
+				GithubRepoPath: ptr.String("team/project"),
+				Provider:       ProviderBitbucket,
+			},
+			Providers:       &SourceProviders{Bitbucket: &MockBitbucketClient{}},
+			ExpectedContent: "console.log('hello bitbucket')",
+		},
+	}
+
+	for _, tt := range tests {
+		errorTrace, err := store.EnhanceTraceWithGitHub(ctx, trace, tt.Service, "v1.2.3", nil, tt.Providers, 0)
+		assert.NoError(t, err)
+		assert.False(t, errorTrace.LineContentStale)
+		assert.Equal(t, tt.ExpectedContent, *errorTrace.LineContent)
+	}
+}
+
 func TestEnhanceTraceWithGitHub(t *testing.T) {
 	defer teardown(t)
 	// test no file name or line number, test matches ignore config, found
@@ -299,9 +401,10 @@ func TestEnhanceTraceWithGitHub(t *testing.T) {
 
 	ctx := context.Background()
 	githubClientMock := MockGithubClient{}
+	providers := &SourceProviders{GitHub: &githubClientMock}
 
 	for _, tt := range tests {
-		errorTrace, err := store.EnhanceTraceWithGitHub(ctx, tt.Trace, tt.Service, tt.ServiceVersion, tt.IgnoredFiles, &githubClientMock)
+		errorTrace, err := store.EnhanceTraceWithGitHub(ctx, tt.Trace, tt.Service, tt.ServiceVersion, tt.IgnoredFiles, providers, 0)
 
 		if tt.ExpectedError {
 			assert.Error(t, err)
@@ -321,6 +424,111 @@ func TestEnhanceTraceWithGitHub(t *testing.T) {
 	}
 }
 
+func TestEnhanceTraceWithGitHubBlame(t *testing.T) {
+	defer teardown(t)
+	ctx := context.Background()
+	githubClientMock := MockGithubClient{}
+
+	trace := &privateModel.ErrorTrace{
+		FileName:     ptr.String("/file.js"),
+		LineNumber:   ptr.Int(1),
+		ColumnNumber: ptr.Int(4),
+		FunctionName: ptr.String(""),
+	}
+	service := &model.Service{
+		GithubRepoPath: ptr.String("highlight/highlight"),
+	}
+
+	providers := &SourceProviders{GitHub: &githubClientMock}
+	errorTrace, err := store.EnhanceTraceWithGitHub(ctx, trace, service, "1234567890", nil, providers, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, "abcdef1", *errorTrace.LineBlameSHA)
+	assert.Equal(t, "Jane Doe", *errorTrace.LineBlameAuthor)
+	assert.Equal(t, "fix: handle edge case", *errorTrace.LineBlameMessage)
+	assert.Equal(t, time.Unix(1700000000, 0), *errorTrace.LineBlameCommittedAt)
+}
+
+// equivalentLineGitProvider is a SourceProvider + FileRevisionProvider used
+// to exercise EnhanceTraceWithGitHub's equivalent-line fallback end to end,
+// the way a non-SHA serviceVersion actually drives it (as opposed to
+// equivalent_line_test.go's direct, isolated calls to ResolveEquivalentLine).
+type equivalentLineGitProvider struct {
+	headSHA   string
+	revisions []string
+	content   map[string]string
+}
+
+func (p *equivalentLineGitProvider) FetchFile(ctx context.Context, repoPath string, filePath string, ref string) (*string, error) {
+	content, ok := p.content[ref]
+	if !ok {
+		return nil, errors.New("no content for ref")
+	}
+	encoded := base64.StdEncoding.EncodeToString([]byte(content))
+	return &encoded, nil
+}
+
+func (p *equivalentLineGitProvider) LatestCommit(ctx context.Context, repoPath string) (*string, error) {
+	return &p.headSHA, nil
+}
+
+func (p *equivalentLineGitProvider) ResolveRef(ctx context.Context, repoPath string, serviceVersion string) (*string, error) {
+	return p.LatestCommit(ctx, repoPath)
+}
+
+func (p *equivalentLineGitProvider) ListFileRevisions(ctx context.Context, repoPath string, filePath string, ref string, maxDepth int) ([]string, error) {
+	if maxDepth < len(p.revisions) {
+		return p.revisions[:maxDepth], nil
+	}
+	return p.revisions, nil
+}
+
+func TestEnhanceTraceWithGitHubEquivalentLine(t *testing.T) {
+	defer teardown(t)
+	ctx := context.Background()
+
+	trace := &privateModel.ErrorTrace{
+		FileName:   ptr.String("/file.js"),
+		LineNumber: ptr.Int(2),
+	}
+	service := &model.Service{
+		GithubRepoPath: ptr.String("org/repo"),
+		Provider:       ProviderGit,
+	}
+
+	t.Run("line unchanged since an earlier revision resolves content", func(t *testing.T) {
+		providers := &SourceProviders{Git: &equivalentLineGitProvider{
+			headSHA:   "head",
+			revisions: []string{"head", "prev", "prev2"},
+			content: map[string]string{
+				"head":  "a\nb\nc",
+				"prev":  "a\nb\nc",
+				"prev2": "a\nCHANGED\nc",
+			},
+		}}
+
+		errorTrace, err := store.EnhanceTraceWithGitHub(ctx, trace, service, "main", nil, providers, 0)
+		assert.NoError(t, err)
+		assert.False(t, errorTrace.LineContentStale)
+		assert.Equal(t, "b", *errorTrace.LineContent)
+	})
+
+	t.Run("line changed in every prior revision marks trace stale", func(t *testing.T) {
+		providers := &SourceProviders{Git: &equivalentLineGitProvider{
+			headSHA:   "head",
+			revisions: []string{"head", "prev"},
+			content: map[string]string{
+				"head": "a\nb\nc",
+				"prev": "a\nCHANGED\nc",
+			},
+		}}
+
+		errorTrace, err := store.EnhanceTraceWithGitHub(ctx, trace, service, "main", nil, providers, 0)
+		assert.NoError(t, err)
+		assert.True(t, errorTrace.LineContentStale)
+		assert.Nil(t, errorTrace.LineContent)
+	})
+}
+
 type MockGithubClient struct{}
 
 func (c *MockGithubClient) GetRepoContent(ctx context.Context, githubPath string, path string, version string) (fileContent *github2.RepositoryContent, directoryContent []*github2.RepositoryContent, resp *github2.Response, err error) {
@@ -366,6 +574,27 @@ func (c *MockGithubClient) GetRepoBlob(ctx context.Context, githubPath string, b
 	return nil, nil, nil
 }
 
+func (c *MockGithubClient) GetBlameForLine(ctx context.Context, githubPath string, filePath string, sha string, lineNumber int) (*BlameRange, error) {
+	if filePath == "/no-blame.js" {
+		return nil, errors.New("blame error")
+	}
+	return &BlameRange{
+		StartingLine: 1,
+		EndingLine:   10,
+		Commit: BlameCommit{
+			SHA:           "abcdef1",
+			Message:       "fix: handle edge case",
+			CommittedDate: time.Unix(1700000000, 0),
+			AuthorName:    "Jane Doe",
+			AuthorEmail:   "jane@example.com",
+		},
+	}, nil
+}
+
+func (c *MockGithubClient) ListFileRevisions(ctx context.Context, githubPath string, filePath string, ref string, maxDepth int) ([]string, error) {
+	return nil, nil
+}
+
 func (c *MockGithubClient) GetLatestCommitHash(ctx context.Context, githubPath string) (string, *github2.Response, error) {
 	if githubPath == "highlight/error" {
 		return "", nil, errors.New("error")
@@ -388,4 +617,4 @@ func (c *MockGithubClient) ListRepos(ctx context.Context) ([]*github2.Repository
 }
 func (c *MockGithubClient) DeleteInstallation(ctx context.Context, installation string) error {
 	return nil
-}
\ No newline at end of file
+}